@@ -31,6 +31,8 @@
 package base
 
 import (
+	"sync/atomic"
+
 	"google.golang.org/grpc/balancer"
 	"google.golang.org/grpc/resolver"
 )
@@ -65,8 +67,27 @@ type PickerBuildInfo struct {
 // SubConnInfo 结构体包含了一个子连接的信息，其中 Address 是用于创建此子连接的地址。
 type SubConnInfo struct {
 	Address resolver.Address // the address used to create this SubConn
+
+	// InFlight is the number of picks of this SubConn that have not yet
+	// completed. NewBalancerBuilder populates it for every PickerBuilder it
+	// builds: it increments the counter for every PickResult its Picker
+	// returns and wraps PickResult.Done to decrement it once the RPC
+	// finishes, regardless of outcome, so load-aware pickers (e.g. p2c) can
+	// read it without needing their own bookkeeping. It's never nil for a
+	// ready SubConn.
+	InFlight *atomic.Int64
 }
 
+// HashKeyFunc extracts a hash key for a pick from the balancer.PickInfo
+// (e.g. a header value or the RPC's context), returning false if the pick
+// carries no usable key. A PickerBuilder that wants request-affinity
+// routing (consistent hashing, ring hash, etc.) can use one of these to
+// have its Picker look up the key itself; Pick, not the base balancer,
+// is what runs per RPC, so the base balancer has no part to play here.
+// HashKeyFunc 从一次选择的 balancer.PickInfo 中提取哈希键（例如某个 header
+// 的值），如果该请求不携带可用的键则返回 false。
+type HashKeyFunc func(info balancer.PickInfo) (key uint64, ok bool)
+
 // Config contains the config info about the base balancer builder.
 // Config 结构体包含了基础负载均衡器构建器的配置信息，
 // 其中 HealthCheck 表示是否为此特定负载均衡器启用健康检查。
@@ -82,7 +103,7 @@ type Config struct {
 func NewBalancerBuilder(name string, pb PickerBuilder, config Config) balancer.Builder {
 	return &baseBuilder{
 		name:          name,
-		pickerBuilder: pb,
+		pickerBuilder: &inFlightTrackingPickerBuilder{wrapped: pb},
 		config:        config,
 	}
 }
@@ -0,0 +1,94 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package base
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc/balancer"
+)
+
+// inFlightTrackingPickerBuilder wraps a PickerBuilder, maintaining a
+// persistent in-flight counter per SubConn and populating
+// SubConnInfo.InFlight with it before delegating to wrapped. NewBalancerBuilder
+// installs one of these around every PickerBuilder it's given, so any
+// picker built on top of this package — not just one that knows to ask for
+// it — can read a SubConn's current in-flight count.
+//
+// Counters are keyed by SubConn identity and carried over from one Build
+// call to the next, since a SubConn that's still ready after a rebuild
+// (e.g. because some other SubConn changed state) should keep the RPCs it
+// already has outstanding.
+type inFlightTrackingPickerBuilder struct {
+	wrapped PickerBuilder
+
+	mu       sync.Mutex
+	counters map[balancer.SubConn]*atomic.Int64
+}
+
+func (b *inFlightTrackingPickerBuilder) Build(info PickerBuildInfo) balancer.Picker {
+	b.mu.Lock()
+	counters := make(map[balancer.SubConn]*atomic.Int64, len(info.ReadySCs))
+	decorated := make(map[balancer.SubConn]SubConnInfo, len(info.ReadySCs))
+	for sc, scInfo := range info.ReadySCs {
+		counter := b.counters[sc]
+		if counter == nil {
+			counter = new(atomic.Int64)
+		}
+		counters[sc] = counter
+		scInfo.InFlight = counter
+		decorated[sc] = scInfo
+	}
+	b.counters = counters
+	b.mu.Unlock()
+
+	picker := b.wrapped.Build(PickerBuildInfo{ReadySCs: decorated})
+	return &inFlightTrackingPicker{wrapped: picker, counters: counters}
+}
+
+// inFlightTrackingPicker increments the chosen SubConn's counter on every
+// Pick and wraps PickResult.Done to decrement it once the RPC finishes,
+// regardless of outcome.
+type inFlightTrackingPicker struct {
+	wrapped  balancer.Picker
+	counters map[balancer.SubConn]*atomic.Int64
+}
+
+func (p *inFlightTrackingPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	result, err := p.wrapped.Pick(info)
+	if err != nil {
+		return result, err
+	}
+
+	counter, ok := p.counters[result.SubConn]
+	if !ok {
+		return result, nil
+	}
+
+	counter.Add(1)
+	innerDone := result.Done
+	result.Done = func(di balancer.DoneInfo) {
+		counter.Add(-1)
+		if innerDone != nil {
+			innerDone(di)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,110 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package base
+
+import (
+	"testing"
+
+	"google.golang.org/grpc/balancer"
+)
+
+type testSubConn struct {
+	balancer.SubConn
+	name string
+}
+
+// passthroughPickerBuilder returns a picker that always picks the first
+// ready SubConn it sees, and records the PickerBuildInfo it was given so
+// tests can inspect the InFlight field NewBalancerBuilder populated.
+type passthroughPickerBuilder struct {
+	lastInfo PickerBuildInfo
+}
+
+func (b *passthroughPickerBuilder) Build(info PickerBuildInfo) balancer.Picker {
+	b.lastInfo = info
+	var sc balancer.SubConn
+	for s := range info.ReadySCs {
+		sc = s
+		break
+	}
+	return &passthroughPicker{sc: sc}
+}
+
+type passthroughPicker struct {
+	sc balancer.SubConn
+}
+
+func (p *passthroughPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	return balancer.PickResult{SubConn: p.sc}, nil
+}
+
+// TestInFlightTrackingIncrementsAndDecrements verifies that the picker
+// returned by NewBalancerBuilder increments a SubConn's InFlight counter on
+// Pick and decrements it once the returned Done is called, regardless of
+// the wrapped PickerBuilder's own Done.
+func TestInFlightTrackingIncrementsAndDecrements(t *testing.T) {
+	inner := &passthroughPickerBuilder{}
+	wrapped := &inFlightTrackingPickerBuilder{wrapped: inner}
+
+	sc := &testSubConn{name: "only"}
+	picker := wrapped.Build(PickerBuildInfo{ReadySCs: map[balancer.SubConn]SubConnInfo{sc: {}}})
+
+	counter := inner.lastInfo.ReadySCs[sc].InFlight
+	if counter == nil {
+		t.Fatal("InFlight is nil; NewBalancerBuilder must populate it before calling the wrapped PickerBuilder")
+	}
+
+	res, err := picker.Pick(balancer.PickInfo{})
+	if err != nil {
+		t.Fatalf("Pick returned unexpected error: %v", err)
+	}
+	if got := counter.Load(); got != 1 {
+		t.Errorf("InFlight after Pick = %d, want 1", got)
+	}
+	if res.Done == nil {
+		t.Fatal("PickResult.Done is nil; the in-flight wrapper must always install one")
+	}
+	res.Done(balancer.DoneInfo{})
+	if got := counter.Load(); got != 0 {
+		t.Errorf("InFlight after Done = %d, want 0", got)
+	}
+}
+
+// TestInFlightTrackingSurvivesRebuild verifies that a SubConn's in-flight
+// count carries over across Build calls, rather than resetting to zero
+// every time the ready set is rebuilt.
+func TestInFlightTrackingSurvivesRebuild(t *testing.T) {
+	inner := &passthroughPickerBuilder{}
+	wrapped := &inFlightTrackingPickerBuilder{wrapped: inner}
+
+	sc := &testSubConn{name: "only"}
+	picker := wrapped.Build(PickerBuildInfo{ReadySCs: map[balancer.SubConn]SubConnInfo{sc: {}}})
+	if _, err := picker.Pick(balancer.PickInfo{}); err != nil {
+		t.Fatalf("Pick returned unexpected error: %v", err)
+	}
+
+	// Rebuild with the same ready set, as the base balancer would on an
+	// unrelated SubConn state change.
+	wrapped.Build(PickerBuildInfo{ReadySCs: map[balancer.SubConn]SubConnInfo{sc: {}}})
+
+	counter := inner.lastInfo.ReadySCs[sc].InFlight
+	if got := counter.Load(); got != 1 {
+		t.Errorf("InFlight after rebuild = %d, want 1 (the outstanding pick from before the rebuild)", got)
+	}
+}
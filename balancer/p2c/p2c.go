@@ -0,0 +1,107 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package p2c implements a power-of-two-choices load balancing policy.
+//
+// On every Pick, two distinct ready SubConns are sampled uniformly at
+// random and the one with the fewer in-flight requests is chosen (ties are
+// broken randomly). This gives most of the benefit of a fully load-aware
+// balancer without requiring backends to report load: a SubConn only ever
+// gets overloaded relative to one randomly chosen peer, which in practice
+// keeps the load distribution close to even.
+//
+// In-flight counts come from base.SubConnInfo.InFlight, which
+// base.NewBalancerBuilder keeps up to date for every ready SubConn; p2c
+// does no in-flight bookkeeping of its own.
+package p2c
+
+import (
+	"math/rand"
+	"sync/atomic"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/grpclog"
+)
+
+// Name is the name of the p2c balancer.
+const Name = "p2c"
+
+var logger = grpclog.Component("p2c")
+
+func newBuilder() balancer.Builder {
+	return base.NewBalancerBuilder(Name, &p2cPickerBuilder{}, base.Config{HealthCheck: true})
+}
+
+func init() {
+	balancer.Register(newBuilder())
+}
+
+type p2cPickerBuilder struct{}
+
+func (*p2cPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	logger.Infof("p2cPicker: Build called with info: %v", info)
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+
+	scs := make([]*p2cSubConn, 0, len(info.ReadySCs))
+	for sc, scInfo := range info.ReadySCs {
+		scs = append(scs, &p2cSubConn{subConn: sc, inFlight: scInfo.InFlight})
+	}
+
+	return &p2cPicker{subConns: scs}
+}
+
+// p2cSubConn pairs a SubConn with the counter used to track its in-flight
+// request count.
+type p2cSubConn struct {
+	subConn  balancer.SubConn
+	inFlight *atomic.Int64
+}
+
+type p2cPicker struct {
+	subConns []*p2cSubConn
+}
+
+func (p *p2cPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	a, b := p.sampleTwo()
+
+	chosen := a
+	if b.inFlight.Load() < a.inFlight.Load() {
+		chosen = b
+	} else if b.inFlight.Load() == a.inFlight.Load() && rand.Intn(2) == 1 {
+		chosen = b
+	}
+
+	return balancer.PickResult{SubConn: chosen.subConn}, nil
+}
+
+// sampleTwo returns two distinct SubConns chosen uniformly at random. If
+// only one SubConn is ready, it's returned for both.
+func (p *p2cPicker) sampleTwo() (*p2cSubConn, *p2cSubConn) {
+	if len(p.subConns) == 1 {
+		return p.subConns[0], p.subConns[0]
+	}
+	i := rand.Intn(len(p.subConns))
+	j := rand.Intn(len(p.subConns) - 1)
+	if j >= i {
+		j++
+	}
+	return p.subConns[i], p.subConns[j]
+}
@@ -0,0 +1,97 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package p2c
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+)
+
+type testSubConn struct {
+	balancer.SubConn
+	name string
+}
+
+func buildPicker(t *testing.T, names []string) (*p2cPicker, map[balancer.SubConn]string) {
+	t.Helper()
+	readySCs := make(map[balancer.SubConn]base.SubConnInfo)
+	scNames := make(map[balancer.SubConn]string)
+	for _, name := range names {
+		sc := &testSubConn{name: name}
+		// p2cPickerBuilder expects the base balancer to have already
+		// populated InFlight, as base.NewBalancerBuilder does; supply it
+		// directly here since these tests build the picker standalone.
+		readySCs[sc] = base.SubConnInfo{Address: resolver.Address{Addr: name}, InFlight: new(atomic.Int64)}
+		scNames[sc] = name
+	}
+
+	p := (&p2cPickerBuilder{}).Build(base.PickerBuildInfo{ReadySCs: readySCs})
+	pp, ok := p.(*p2cPicker)
+	if !ok {
+		t.Fatalf("Build returned %T, want *p2cPicker", p)
+	}
+	return pp, scNames
+}
+
+// TestP2CPrefersLessLoaded verifies that once one SubConn has a
+// meaningfully higher in-flight count, p2c steers the large majority of
+// subsequent picks to the less-loaded one.
+func TestP2CPrefersLessLoaded(t *testing.T) {
+	p, names := buildPicker(t, []string{"busy", "idle"})
+
+	// Load up "busy" with a lot of outstanding (never-finished) picks so
+	// its in-flight counter stays high throughout the test.
+	for _, sc := range p.subConns {
+		if names[sc.subConn] == "busy" {
+			sc.inFlight.Store(1000)
+		}
+	}
+
+	const n = 2000
+	counts := map[string]int{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := p.Pick(balancer.PickInfo{})
+			if err != nil {
+				t.Errorf("Pick returned unexpected error: %v", err)
+				return
+			}
+			mu.Lock()
+			counts[names[res.SubConn]]++
+			mu.Unlock()
+			if res.Done != nil {
+				res.Done(balancer.DoneInfo{})
+			}
+		}()
+	}
+	wg.Wait()
+
+	if counts["idle"] < n*9/10 {
+		t.Errorf("picks to idle SubConn = %d/%d, want at least 90%% given it's far less loaded", counts["idle"], n)
+	}
+}
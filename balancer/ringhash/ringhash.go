@@ -0,0 +1,273 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package ringhash implements a consistent-hash picker on top of
+// balancer/base, giving request-affinity routing (session-sticky RPCs,
+// cache-shard routing, ...) to any balancer built from base.NewBalancerBuilder.
+//
+// Each ready SubConn is placed on virtualNodesPerHost points on a 64-bit
+// ring. A pick hashes the RPC (via the key extractor installed with
+// SetHashKey) and is routed to the SubConn owning the first ring point at
+// or after that hash, wrapping around to the start of the ring if
+// necessary. RPCs that don't carry a hashable key fall back to plain round
+// robin over the ready set.
+//
+// ring_hash is registered under Name, so it can be selected from a service
+// config's loadBalancingConfig the same way as weighted_round_robin, p2c
+// or subset, with {"virtualNodesPerHost": N} as its config; N defaults to
+// defaultVirtualNodesPerHost if omitted or zero.
+package ringhash
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+// Name is the name of the ring_hash balancer.
+const Name = "ring_hash"
+
+// defaultVirtualNodesPerHost is used when the service config doesn't
+// specify one.
+const defaultVirtualNodesPerHost = 100
+
+var logger = grpclog.Component("ringhash")
+
+// hashKey holds the process-wide base.HashKeyFunc installed by SetHashKey,
+// read by every ring_hash Pick. It's an atomic.Pointer, not a bare var,
+// because Pick runs concurrently with any SetHashKey call reloading it.
+var hashKey atomic.Pointer[base.HashKeyFunc]
+
+// SetHashKey installs fn as the key extractor used by Pick to look up a
+// request's hash (e.g. read a specific header). This is a single
+// process-wide knob, not something a picker builder registers per
+// balancer instance: it affects every ring_hash picker in the process, and
+// only the most recently installed fn applies. Pass nil to make every pick
+// fall back to round robin.
+func SetHashKey(fn base.HashKeyFunc) {
+	hashKey.Store(&fn)
+}
+
+func init() {
+	balancer.Register(ringhashBalancerBuilder{})
+}
+
+// ringhashBalancerBuilder registers "ring_hash" as a selectable balancer,
+// mirroring balancer/subset's wrapper: Build wraps a
+// base.NewBalancerBuilder-constructed balancer.Balancer, and this wrapper's
+// only job is to push each update's parsed Config into the PickerBuilder
+// before forwarding, since base.PickerBuilder.Build only ever sees
+// PickerBuildInfo, not the service config.
+type ringhashBalancerBuilder struct{}
+
+func (ringhashBalancerBuilder) Name() string { return Name }
+
+func (ringhashBalancerBuilder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	pb := &ringhashPickerBuilder{virtualNodesPerHost: defaultVirtualNodesPerHost}
+	inner := base.NewBalancerBuilder(Name, pb, base.Config{HealthCheck: true}).Build(cc, opts)
+	return &ringhashBalancer{pickerBuilder: pb, inner: inner}
+}
+
+func (ringhashBalancerBuilder) ParseConfig(js json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	return ParseConfig(js)
+}
+
+// ringhashBalancer forwards everything to inner, except that it updates
+// pickerBuilder's virtual-node count from each ClientConnState's
+// BalancerConfig first, so that by the time inner rebuilds its picker, the
+// PickerBuilder is sizing the ring according to the newest service config.
+type ringhashBalancer struct {
+	pickerBuilder *ringhashPickerBuilder
+	inner         balancer.Balancer
+}
+
+func (b *ringhashBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	if cfg, ok := s.BalancerConfig.(*Config); ok && cfg != nil {
+		b.pickerBuilder.setVirtualNodesPerHost(cfg.VirtualNodesPerHost)
+	}
+	return b.inner.UpdateClientConnState(s)
+}
+
+func (b *ringhashBalancer) ResolverError(err error) {
+	b.inner.ResolverError(err)
+}
+
+func (b *ringhashBalancer) UpdateSubConnState(sc balancer.SubConn, state balancer.SubConnState) {
+	b.inner.UpdateSubConnState(sc, state)
+}
+
+func (b *ringhashBalancer) Close() {
+	b.inner.Close()
+}
+
+// Config controls ring_hash's virtual-node density.
+type Config struct {
+	serviceconfig.LoadBalancingConfig
+
+	// VirtualNodesPerHost is the number of ring positions each ready
+	// backend is given. If 0, defaultVirtualNodesPerHost is used.
+	VirtualNodesPerHost uint32 `json:"virtualNodesPerHost,omitempty"`
+}
+
+// ParseConfig parses a ring_hash service config.
+func ParseConfig(js json.RawMessage) (*Config, error) {
+	cfg := &Config{}
+	if err := json.Unmarshal(js, cfg); err != nil {
+		return nil, fmt.Errorf("ringhash: unable to unmarshal Config: %v", err)
+	}
+	return cfg, nil
+}
+
+// ringhashPickerBuilder builds the consistent-hash picker, sizing the ring
+// according to virtualNodesPerHost.
+type ringhashPickerBuilder struct {
+	mu                  sync.Mutex
+	virtualNodesPerHost uint32
+}
+
+// setVirtualNodesPerHost updates the virtual-node count used by future
+// Build calls. It's safe to call concurrently with Build.
+func (b *ringhashPickerBuilder) setVirtualNodesPerHost(n uint32) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.virtualNodesPerHost = n
+}
+
+func (b *ringhashPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	logger.Infof("ringhashPicker: Build called with info: %v", info)
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+
+	scs := make([]balancer.SubConn, 0, len(info.ReadySCs))
+	for sc := range info.ReadySCs {
+		scs = append(scs, sc)
+	}
+
+	b.mu.Lock()
+	nodesPerHost := b.virtualNodesPerHost
+	b.mu.Unlock()
+	if nodesPerHost == 0 {
+		nodesPerHost = defaultVirtualNodesPerHost
+	}
+
+	ring := make(ringEntries, 0, len(scs)*int(nodesPerHost))
+	for sc := range info.ReadySCs {
+		addr := info.ReadySCs[sc].Address.Addr
+		for v := uint32(0); v < nodesPerHost; v++ {
+			ring = append(ring, ringEntry{hash: virtualNodeHash(addr, v), subConn: sc})
+		}
+	}
+	sort.Sort(ring)
+
+	return &ringhashPicker{
+		ring: ring,
+		rrFallback: &roundRobinFallback{
+			subConns: scs,
+			next:     uint32(rand.Intn(len(scs))),
+		},
+	}
+}
+
+// virtualNodeHash derives a ring position for virtual node v of the
+// backend at addr. It's keyed by the address itself, not by the backend's
+// position in any slice or map range (map iteration order is randomized
+// per process, and even a stable slice order can shift across Build calls
+// that add or remove unrelated SubConns), so a given backend always lands
+// on the same ring points across rebuilds. It doesn't need to be
+// cryptographically strong, only well distributed across the 64-bit space.
+func virtualNodeHash(addr string, v uint32) uint64 {
+	buf := make([]byte, 0, len(addr)+4)
+	buf = append(buf, addr...)
+	var vBuf [4]byte
+	binary.LittleEndian.PutUint32(vBuf[:], v)
+	buf = append(buf, vBuf[:]...)
+	return fnv1a64(buf)
+}
+
+func fnv1a64(data []byte) uint64 {
+	const offset64 = 14695981039346656037
+	const prime64 = 1099511628211
+	h := uint64(offset64)
+	for _, b := range data {
+		h ^= uint64(b)
+		h *= prime64
+	}
+	return h
+}
+
+type ringEntry struct {
+	hash    uint64
+	subConn balancer.SubConn
+}
+
+type ringEntries []ringEntry
+
+func (r ringEntries) Len() int           { return len(r) }
+func (r ringEntries) Less(i, j int) bool { return r[i].hash < r[j].hash }
+func (r ringEntries) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+
+// ringhashPicker routes by consistent hash when the RPC carries a hash
+// key, and falls back to round robin otherwise.
+type ringhashPicker struct {
+	// ring is sorted once at Build time and never mutated afterwards.
+	ring       ringEntries
+	rrFallback *roundRobinFallback
+}
+
+func (p *ringhashPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	hk := hashKey.Load()
+	if hk == nil || *hk == nil {
+		return p.rrFallback.pick()
+	}
+	hash, ok := (*hk)(info)
+	if !ok {
+		return p.rrFallback.pick()
+	}
+
+	// Binary search for the first virtual node whose hash is >= the
+	// request's hash, wrapping around to the start of the ring if the
+	// request hash is past the last node.
+	idx := sort.Search(len(p.ring), func(i int) bool { return p.ring[i].hash >= hash })
+	if idx == len(p.ring) {
+		idx = 0
+	}
+	return balancer.PickResult{SubConn: p.ring[idx].subConn}, nil
+}
+
+// roundRobinFallback is used for picks that carry no hash key, mirroring
+// balancer/roundrobin's "don't always start at index 0" behavior.
+type roundRobinFallback struct {
+	subConns []balancer.SubConn
+	next     uint32
+}
+
+func (r *roundRobinFallback) pick() (balancer.PickResult, error) {
+	nextIndex := atomic.AddUint32(&r.next, 1)
+	sc := r.subConns[nextIndex%uint32(len(r.subConns))]
+	return balancer.PickResult{SubConn: sc}, nil
+}
@@ -0,0 +1,164 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package ringhash
+
+import (
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+)
+
+type testSubConn struct {
+	balancer.SubConn
+	name string
+}
+
+func buildPicker(t *testing.T, addrs []string) (*ringhashPicker, map[balancer.SubConn]string) {
+	t.Helper()
+	readySCs := make(map[balancer.SubConn]base.SubConnInfo)
+	names := make(map[balancer.SubConn]string)
+	for _, addr := range addrs {
+		sc := &testSubConn{name: addr}
+		readySCs[sc] = base.SubConnInfo{Address: resolver.Address{Addr: addr}}
+		names[sc] = addr
+	}
+
+	p := (&ringhashPickerBuilder{virtualNodesPerHost: defaultVirtualNodesPerHost}).Build(base.PickerBuildInfo{ReadySCs: readySCs})
+	rp, ok := p.(*ringhashPicker)
+	if !ok {
+		t.Fatalf("Build returned %T, want *ringhashPicker", p)
+	}
+	return rp, names
+}
+
+// TestRingHashStableAcrossRebuilds verifies that a given request hash maps
+// to the same backend across independent Build calls for the same ready
+// set, even though Go map iteration order is randomized. This guards
+// against ring positions being derived from map/slice iteration order
+// instead of a stable per-backend key.
+func TestRingHashStableAcrossRebuilds(t *testing.T) {
+	addrs := []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80", "10.0.0.4:80", "10.0.0.5:80"}
+	const hash = uint64(123456789)
+
+	p1, names1 := buildPicker(t, addrs)
+	SetHashKey(func(balancer.PickInfo) (uint64, bool) { return hash, true })
+	defer SetHashKey(nil)
+
+	res1, err := p1.Pick(balancer.PickInfo{})
+	if err != nil {
+		t.Fatalf("Pick returned unexpected error: %v", err)
+	}
+	first := names1[res1.SubConn]
+
+	for i := 0; i < 20; i++ {
+		p, names := buildPicker(t, addrs)
+		res, err := p.Pick(balancer.PickInfo{})
+		if err != nil {
+			t.Fatalf("Pick returned unexpected error: %v", err)
+		}
+		if got := names[res.SubConn]; got != first {
+			t.Fatalf("rebuild %d: hash %d routed to %q, want %q (same as the first build)", i, hash, got, first)
+		}
+	}
+}
+
+// TestRingHashFallsBackToRoundRobin verifies that picks with no hash key
+// are distributed round-robin over the ready set rather than all landing
+// on the same SubConn.
+func TestRingHashFallsBackToRoundRobin(t *testing.T) {
+	addrs := []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80"}
+	p, names := buildPicker(t, addrs)
+	SetHashKey(nil)
+
+	seen := map[string]bool{}
+	for i := 0; i < 30; i++ {
+		res, err := p.Pick(balancer.PickInfo{})
+		if err != nil {
+			t.Fatalf("Pick returned unexpected error: %v", err)
+		}
+		seen[names[res.SubConn]] = true
+	}
+	if len(seen) != len(addrs) {
+		t.Errorf("round-robin fallback visited %d distinct SubConns out of %d, want all of them", len(seen), len(addrs))
+	}
+}
+
+// TestRingHashPickerBuilderAppliesVirtualNodesPerHost verifies that
+// setVirtualNodesPerHost, which ringhashBalancer calls with each service
+// config's VirtualNodesPerHost, sets the ring size of the next Build. It
+// calls the picker builder directly; the ringhashBalancer wrapper is not
+// exercised.
+func TestRingHashPickerBuilderAppliesVirtualNodesPerHost(t *testing.T) {
+	pb := &ringhashPickerBuilder{virtualNodesPerHost: defaultVirtualNodesPerHost}
+
+	readySCs := map[balancer.SubConn]base.SubConnInfo{
+		&testSubConn{name: "a"}: {Address: resolver.Address{Addr: "a"}},
+	}
+
+	const n = 7
+	pb.setVirtualNodesPerHost(n)
+
+	p := pb.Build(base.PickerBuildInfo{ReadySCs: readySCs})
+	rp, ok := p.(*ringhashPicker)
+	if !ok {
+		t.Fatalf("Build returned %T, want *ringhashPicker", p)
+	}
+	if got := len(rp.ring); got != n {
+		t.Errorf("ring size after setVirtualNodesPerHost(%d) = %d, want %d", n, got, n)
+	}
+}
+
+// TestSetHashKeyConcurrentWithPick exercises SetHashKey racing with Pick
+// under -race: hashKey must be safe to reload while picks are in flight,
+// since a bare package-level var read directly by Pick would be a data
+// race under concurrent config reload.
+func TestSetHashKeyConcurrentWithPick(t *testing.T) {
+	p, _ := buildPicker(t, []string{"10.0.0.1:80", "10.0.0.2:80"})
+	defer SetHashKey(nil)
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		i := uint64(0)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				i++
+				hash := i
+				SetHashKey(func(balancer.PickInfo) (uint64, bool) { return hash, true })
+			}
+		}
+	}()
+
+	for i := 0; i < 1000; i++ {
+		if _, err := p.Pick(balancer.PickInfo{}); err != nil {
+			t.Fatalf("Pick returned unexpected error: %v", err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
@@ -0,0 +1,256 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package subset implements deterministic subsetting: rather than every
+// client opening a subchannel to every backend in a large fleet, each
+// client is deterministically restricted to a fixed-size subset of the
+// backends, while every backend still receives roughly equal coverage
+// across clients.
+//
+// subset doesn't implement picking itself; internally it wraps another
+// base.PickerBuilder (round robin by default) and filters the ready set
+// down to the client's subset before delegating, so it composes with the
+// existing base.PickerBuildInfo flow without changing the wrapped picker.
+// It's registered under Name, so it can be selected from a service config's
+// loadBalancingConfig the same way as weighted_round_robin, p2c or
+// ring_hash, with {"subsetSize": N, "clientID": "..."} as its config.
+package subset
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+// Name is the name of the subset balancer.
+const Name = "subset"
+
+var logger = grpclog.Component("subset")
+
+func init() {
+	balancer.Register(subsetBalancerBuilder{})
+}
+
+// subsetBalancerBuilder registers "subset" as a selectable balancer. Build
+// wraps a base.NewBalancerBuilder-constructed balancer.Balancer: SubConn
+// lifecycle and picker regeneration are handled entirely by that inner
+// balancer, same as every other balancer in this series; this wrapper's
+// only job is to hand the latest parsed Config to the PickerBuilder before
+// forwarding each ClientConnState update, since base.PickerBuilder.Build
+// only ever sees PickerBuildInfo, not the service config.
+type subsetBalancerBuilder struct{}
+
+func (subsetBalancerBuilder) Name() string { return Name }
+
+func (subsetBalancerBuilder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	pb := &subsettingPickerBuilder{wrapped: &roundRobinPickerBuilder{}}
+	inner := base.NewBalancerBuilder(Name, pb, base.Config{HealthCheck: true}).Build(cc, opts)
+	return &subsetBalancer{pickerBuilder: pb, inner: inner}
+}
+
+func (subsetBalancerBuilder) ParseConfig(js json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	return ParseConfig(js)
+}
+
+// subsetBalancer forwards everything to inner, except that it updates
+// pickerBuilder's Config from each ClientConnState's BalancerConfig first,
+// so that by the time inner rebuilds its picker, the PickerBuilder is
+// subsetting according to the newest service config.
+type subsetBalancer struct {
+	pickerBuilder *subsettingPickerBuilder
+	inner         balancer.Balancer
+}
+
+func (b *subsetBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	if cfg, ok := s.BalancerConfig.(*Config); ok && cfg != nil {
+		b.pickerBuilder.setConfig(*cfg)
+	}
+	return b.inner.UpdateClientConnState(s)
+}
+
+func (b *subsetBalancer) ResolverError(err error) {
+	b.inner.ResolverError(err)
+}
+
+func (b *subsetBalancer) UpdateSubConnState(sc balancer.SubConn, state balancer.SubConnState) {
+	b.inner.UpdateSubConnState(sc, state)
+}
+
+func (b *subsetBalancer) Close() {
+	b.inner.Close()
+}
+
+// roundRobinPickerBuilder is balancer/roundrobin's picker, duplicated here
+// because rrPickerBuilder isn't exported: subset needs a concrete default
+// to wrap when it's selected directly via service config, rather than via
+// NewPickerBuilder with an explicit wrapped builder.
+type roundRobinPickerBuilder struct{}
+
+func (*roundRobinPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	scs := make([]balancer.SubConn, 0, len(info.ReadySCs))
+	for sc := range info.ReadySCs {
+		scs = append(scs, sc)
+	}
+	return &roundRobinPicker{subConns: scs, next: uint32(rand.Intn(len(scs)))}
+}
+
+type roundRobinPicker struct {
+	subConns []balancer.SubConn
+	next     uint32
+}
+
+func (p *roundRobinPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	nextIndex := atomic.AddUint32(&p.next, 1)
+	sc := p.subConns[nextIndex%uint32(len(p.subConns))]
+	return balancer.PickResult{SubConn: sc}, nil
+}
+
+// Config controls how a client's subset of backends is chosen.
+type Config struct {
+	serviceconfig.LoadBalancingConfig
+
+	// SubsetSize is the number of backends each client should use. If it's
+	// 0, or >= the number of ready backends, subsetting is a no-op and the
+	// wrapped picker sees the full ready set.
+	SubsetSize uint32 `json:"subsetSize,omitempty"`
+
+	// ClientID identifies this client among all clients of the backend
+	// fleet. It should be stable across a client's restarts (e.g. a pod
+	// name), but doesn't need to be numeric or dense; it's hashed into the
+	// round/offset computation below.
+	ClientID string `json:"clientID,omitempty"`
+}
+
+// ParseConfig parses a subset service config.
+func ParseConfig(js json.RawMessage) (*Config, error) {
+	cfg := &Config{}
+	if err := json.Unmarshal(js, cfg); err != nil {
+		return nil, fmt.Errorf("subset: unable to unmarshal Config: %v", err)
+	}
+	return cfg, nil
+}
+
+// NewPickerBuilder returns a base.PickerBuilder that restricts the ready
+// set to cfg's deterministic subset before delegating to wrapped.
+func NewPickerBuilder(wrapped base.PickerBuilder, cfg Config) base.PickerBuilder {
+	return &subsettingPickerBuilder{wrapped: wrapped, cfg: cfg}
+}
+
+type subsettingPickerBuilder struct {
+	wrapped base.PickerBuilder
+
+	mu  sync.Mutex
+	cfg Config
+}
+
+// setConfig updates the Config used by future Build calls. It's safe to
+// call concurrently with Build.
+func (b *subsettingPickerBuilder) setConfig(cfg Config) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.cfg = cfg
+}
+
+func (b *subsettingPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	b.mu.Lock()
+	cfg := b.cfg
+	b.mu.Unlock()
+
+	if cfg.SubsetSize == 0 || uint32(len(info.ReadySCs)) <= cfg.SubsetSize {
+		return b.wrapped.Build(info)
+	}
+
+	subset := selectSubset(info.ReadySCs, cfg)
+	logger.Infof("subset: restricted %d ready SubConns to a subset of %d for clientID %q", len(info.ReadySCs), len(subset), cfg.ClientID)
+	return b.wrapped.Build(base.PickerBuildInfo{ReadySCs: subset})
+}
+
+// selectSubset picks the deterministic subset of readySCs for cfg. The
+// sorted backend list is divided into subsetCount = len(backends)/subsetSize
+// non-overlapping groups of subsetSize; a client's round is
+// clientIndex/subsetCount, and within a round the backend list is
+// pseudo-randomly permuted with a seed derived from the round number and
+// chopped into those same groups, so that every client in the round gets a
+// distinct, equally-sized group. clientIndex (the "clientID" of the
+// request's round/offset split) is a hash of cfg.ClientID, and the client's
+// group within its round is clientIndex%subsetCount. Because every group in
+// a round is the same size, every backend gets roughly equal coverage.
+// Every round permutes the whole sorted backend list, so adding or removing
+// a backend changes the permutation of every round (and may change
+// subsetCount, and with it each client's round and group); the number of
+// clients whose subset changes is not bounded.
+func selectSubset(readySCs map[balancer.SubConn]base.SubConnInfo, cfg Config) map[balancer.SubConn]base.SubConnInfo {
+	type backend struct {
+		addr string
+		scs  []balancer.SubConn
+	}
+	byAddr := make(map[string][]balancer.SubConn)
+	for sc, scInfo := range readySCs {
+		byAddr[scInfo.Address.Addr] = append(byAddr[scInfo.Address.Addr], sc)
+	}
+	backends := make([]backend, 0, len(byAddr))
+	for addr, scs := range byAddr {
+		backends = append(backends, backend{addr: addr, scs: scs})
+	}
+	sort.Slice(backends, func(i, j int) bool { return backends[i].addr < backends[j].addr })
+
+	subsetCount := len(backends) / int(cfg.SubsetSize)
+	if subsetCount == 0 {
+		subsetCount = 1
+	}
+
+	clientIndex := hashClientID(cfg.ClientID)
+	round := clientIndex / uint64(subsetCount)
+	group := int(clientIndex % uint64(subsetCount))
+
+	shuffled := append([]backend(nil), backends...)
+	rnd := rand.New(rand.NewSource(int64(round)))
+	rnd.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+
+	start := group * int(cfg.SubsetSize)
+	end := start + int(cfg.SubsetSize)
+	if end > len(shuffled) {
+		end = len(shuffled)
+	}
+
+	out := make(map[balancer.SubConn]base.SubConnInfo)
+	for _, b := range shuffled[start:end] {
+		for _, sc := range b.scs {
+			out[sc] = readySCs[sc]
+		}
+	}
+	return out
+}
+
+func hashClientID(clientID string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(clientID))
+	return h.Sum64()
+}
@@ -0,0 +1,150 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package subset
+
+import (
+	"fmt"
+	"testing"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+)
+
+type testSubConn struct {
+	balancer.SubConn
+	name string
+}
+
+func readySCs(addrs []string) map[balancer.SubConn]base.SubConnInfo {
+	out := make(map[balancer.SubConn]base.SubConnInfo, len(addrs))
+	for _, addr := range addrs {
+		out[&testSubConn{name: addr}] = base.SubConnInfo{Address: resolver.Address{Addr: addr}}
+	}
+	return out
+}
+
+func addrsOf(scs map[balancer.SubConn]base.SubConnInfo) map[string]bool {
+	out := make(map[string]bool, len(scs))
+	for sc := range scs {
+		out[sc.(*testSubConn).name] = true
+	}
+	return out
+}
+
+func manyAddrs(n int) []string {
+	addrs := make([]string, n)
+	for i := range addrs {
+		addrs[i] = fmt.Sprintf("10.0.0.%d:80", i)
+	}
+	return addrs
+}
+
+// TestSelectSubsetIsDeterministic verifies that the same (clientID,
+// subsetSize, backend list) always produces the same subset.
+func TestSelectSubsetIsDeterministic(t *testing.T) {
+	scs := readySCs(manyAddrs(20))
+	cfg := Config{SubsetSize: 4, ClientID: "client-a"}
+
+	first := addrsOf(selectSubset(scs, cfg))
+	for i := 0; i < 10; i++ {
+		got := addrsOf(selectSubset(scs, cfg))
+		if len(got) != len(first) {
+			t.Fatalf("run %d: got %d backends, want %d", i, len(got), len(first))
+		}
+		for addr := range first {
+			if !got[addr] {
+				t.Fatalf("run %d: subset dropped %q that was present before", i, addr)
+			}
+		}
+	}
+}
+
+// TestSelectSubsetSizeHonored verifies that each client's subset has
+// exactly SubsetSize backends when the fleet is large enough to not need
+// a short last group.
+func TestSelectSubsetSizeHonored(t *testing.T) {
+	scs := readySCs(manyAddrs(20))
+	for _, clientID := range []string{"client-a", "client-b", "client-c", "client-d"} {
+		cfg := Config{SubsetSize: 5, ClientID: clientID}
+		got := selectSubset(scs, cfg)
+		if len(got) != int(cfg.SubsetSize) {
+			t.Errorf("clientID %q: subset size = %d, want %d", clientID, len(got), cfg.SubsetSize)
+		}
+	}
+}
+
+// TestSelectSubsetCoverage verifies that, across many distinct clients,
+// every backend is selected by a roughly equal number of them (no backend
+// is starved or over-represented).
+func TestSelectSubsetCoverage(t *testing.T) {
+	addrs := manyAddrs(20)
+	scs := readySCs(addrs)
+	const subsetSize = 4
+	const numClients = 2000
+
+	coverage := map[string]int{}
+	for i := 0; i < numClients; i++ {
+		cfg := Config{SubsetSize: subsetSize, ClientID: fmt.Sprintf("client-%d", i)}
+		for addr := range addrsOf(selectSubset(scs, cfg)) {
+			coverage[addr]++
+		}
+	}
+
+	expected := numClients * subsetSize / len(addrs)
+	for _, addr := range addrs {
+		got := coverage[addr]
+		if got < expected/2 || got > expected*3/2 {
+			t.Errorf("backend %q covered by %d/%d clients, want close to %d", addr, got, numClients, expected)
+		}
+	}
+}
+
+// TestSubsettingPickerBuilderNoOpBelowSubsetSize verifies that subsetting
+// is skipped entirely when there aren't more ready backends than the
+// configured subset size.
+func TestSubsettingPickerBuilderNoOpBelowSubsetSize(t *testing.T) {
+	scs := readySCs(manyAddrs(3))
+	pb := NewPickerBuilder(&roundRobinPickerBuilder{}, Config{SubsetSize: 4, ClientID: "client-a"}).(*subsettingPickerBuilder)
+
+	p := pb.Build(base.PickerBuildInfo{ReadySCs: scs})
+	rp, ok := p.(*roundRobinPicker)
+	if !ok {
+		t.Fatalf("Build returned %T, want *roundRobinPicker (no-op passthrough)", p)
+	}
+	if len(rp.subConns) != len(scs) {
+		t.Errorf("passthrough picker has %d SubConns, want %d", len(rp.subConns), len(scs))
+	}
+}
+
+// TestSubsettingPickerBuilderRestrictsReadySet verifies that the wrapped
+// picker builder only ever sees the client's subset, not the full ready set.
+func TestSubsettingPickerBuilderRestrictsReadySet(t *testing.T) {
+	scs := readySCs(manyAddrs(20))
+	pb := NewPickerBuilder(&roundRobinPickerBuilder{}, Config{SubsetSize: 5, ClientID: "client-a"}).(*subsettingPickerBuilder)
+
+	p := pb.Build(base.PickerBuildInfo{ReadySCs: scs})
+	rp, ok := p.(*roundRobinPicker)
+	if !ok {
+		t.Fatalf("Build returned %T, want *roundRobinPicker", p)
+	}
+	if len(rp.subConns) != 5 {
+		t.Errorf("wrapped picker saw %d SubConns, want 5", len(rp.subConns))
+	}
+}
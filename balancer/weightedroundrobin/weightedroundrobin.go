@@ -0,0 +1,181 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package weightedroundrobin defines a weighted roundrobin balancer.
+//
+// Unlike roundrobin, which gives every ready SubConn an equal share of
+// picks, weightedroundrobin distributes picks in proportion to a weight
+// carried on the resolver.Address for each endpoint. Endpoints that don't
+// carry a weight (or carry a non-positive one) are treated as weight 1, so
+// this balancer degrades to plain round robin when no weights are set.
+package weightedroundrobin
+
+import (
+	"container/heap"
+	"math/rand"
+	"sync"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/resolver"
+)
+
+// Name is the name of the weighted_round_robin balancer.
+const Name = "weighted_round_robin"
+
+var logger = grpclog.Component("weightedroundrobin")
+
+// attributeKey is the resolver.Address.Attributes key under which the
+// AddrInfo for an address is stored.
+type attributeKey struct{}
+
+// AddrInfo carries the weight for an address. It is attached to a
+// resolver.Address via SetAddrInfo and read back via GetAddrInfo.
+type AddrInfo struct {
+	// Weight is the relative weight of this address. Weights are only
+	// meaningful relative to one another; a weight of 0 (or the absence of
+	// an AddrInfo altogether) is treated as a weight of 1.
+	Weight uint32
+}
+
+// Equal allows the attributes package to compare two AddrInfo values, so
+// that resolver updates that don't actually change any weights don't
+// trigger an unnecessary picker rebuild.
+func (a AddrInfo) Equal(o interface{}) bool {
+	oa, ok := o.(AddrInfo)
+	return ok && oa.Weight == a.Weight
+}
+
+// SetAddrInfo returns a copy of addr with addrInfo stored in its
+// Attributes. This is intended to be called by a resolver to associate a
+// weight with an address it returns.
+func SetAddrInfo(addr resolver.Address, addrInfo AddrInfo) resolver.Address {
+	addr.Attributes = addr.Attributes.WithValue(attributeKey{}, addrInfo)
+	return addr
+}
+
+// GetAddrInfo returns the AddrInfo stored in addr's Attributes, and false
+// if addr carries no weight (in which case a weight of 1 should be used).
+func GetAddrInfo(addr resolver.Address) (AddrInfo, bool) {
+	v := addr.Attributes.Value(attributeKey{})
+	ai, ok := v.(AddrInfo)
+	return ai, ok
+}
+
+func newBuilder() balancer.Builder {
+	return base.NewBalancerBuilder(Name, &wrrPickerBuilder{}, base.Config{HealthCheck: true})
+}
+
+func init() {
+	balancer.Register(newBuilder())
+}
+
+type wrrPickerBuilder struct{}
+
+// Build builds an EDF-scheduled weighted picker from the ready SubConns.
+// SubConns with no weight attribute (or a weight of 0) are treated as
+// weight 1, same as roundrobin.
+func (*wrrPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	logger.Infof("wrrPicker: Build called with info: %v", info)
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+
+	items := make([]*scheduleItem, 0, len(info.ReadySCs))
+	for sc, scInfo := range info.ReadySCs {
+		weight := uint32(1)
+		if ai, ok := GetAddrInfo(scInfo.Address); ok && ai.Weight > 0 {
+			weight = ai.Weight
+		}
+		items = append(items, &scheduleItem{
+			subConn: sc,
+			weight:  weight,
+			// Randomize the starting deadline of each SubConn so that a
+			// picker rebuild doesn't pin all traffic back onto the same
+			// SubConn, mirroring the "don't always start at index 0"
+			// behavior in rrPicker.
+			deadline: rand.Float64() / float64(weight),
+		})
+	}
+
+	h := make(schedule, len(items))
+	copy(h, items)
+	heap.Init(&h)
+
+	return &wrrPicker{schedule: h}
+}
+
+// scheduleItem is one SubConn's entry in the EDF min-heap. deadline is the
+// virtual time at which this SubConn is next due to be picked.
+type scheduleItem struct {
+	subConn  balancer.SubConn
+	weight   uint32
+	deadline float64
+	index    int
+}
+
+// schedule implements heap.Interface over the set of ready SubConns,
+// ordered by increasing deadline.
+type schedule []*scheduleItem
+
+func (s schedule) Len() int            { return len(s) }
+func (s schedule) Less(i, j int) bool  { return s[i].deadline < s[j].deadline }
+func (s schedule) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+	s[i].index = i
+	s[j].index = j
+}
+
+func (s *schedule) Push(x interface{}) {
+	item := x.(*scheduleItem)
+	item.index = len(*s)
+	*s = append(*s, item)
+}
+
+func (s *schedule) Pop() interface{} {
+	old := *s
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*s = old[:n-1]
+	return item
+}
+
+// wrrPicker picks SubConns using an Earliest-Deadline-First scheduler: the
+// SubConn with the smallest deadline is popped, vtime is advanced to its
+// deadline, its deadline is advanced by 1/weight, and it's pushed back.
+// Over time this converges to picking each SubConn in proportion to its
+// weight.
+type wrrPicker struct {
+	mu       sync.Mutex
+	schedule schedule
+	vtime    float64
+}
+
+func (p *wrrPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	item := p.schedule[0]
+	p.vtime = item.deadline
+	item.deadline = p.vtime + 1/float64(item.weight)
+	heap.Fix(&p.schedule, 0)
+
+	return balancer.PickResult{SubConn: item.subConn}, nil
+}
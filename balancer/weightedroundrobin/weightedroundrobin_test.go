@@ -0,0 +1,109 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package weightedroundrobin
+
+import (
+	"math"
+	"testing"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+)
+
+// testSubConn is a minimal balancer.SubConn used only to give each entry a
+// distinct identity; none of its methods are expected to be called.
+type testSubConn struct {
+	balancer.SubConn
+	name string
+}
+
+func buildPicker(t *testing.T, weights map[string]uint32) (*wrrPicker, map[balancer.SubConn]string) {
+	t.Helper()
+	readySCs := make(map[balancer.SubConn]base.SubConnInfo)
+	names := make(map[balancer.SubConn]string)
+	for name, weight := range weights {
+		sc := &testSubConn{name: name}
+		addr := resolver.Address{Addr: name}
+		if weight > 0 {
+			addr = SetAddrInfo(addr, AddrInfo{Weight: weight})
+		}
+		readySCs[sc] = base.SubConnInfo{Address: addr}
+		names[sc] = name
+	}
+
+	p := (&wrrPickerBuilder{}).Build(base.PickerBuildInfo{ReadySCs: readySCs})
+	wp, ok := p.(*wrrPicker)
+	if !ok {
+		t.Fatalf("Build returned %T, want *wrrPicker", p)
+	}
+	return wp, names
+}
+
+// TestWeightedRoundRobinDistribution verifies that, over many picks, the
+// fraction of picks for each SubConn converges to its configured weight
+// ratio.
+func TestWeightedRoundRobinDistribution(t *testing.T) {
+	weights := map[string]uint32{"a": 1, "b": 2, "c": 5}
+	p, names := buildPicker(t, weights)
+
+	const n = 80000
+	counts := map[string]int{}
+	for i := 0; i < n; i++ {
+		res, err := p.Pick(balancer.PickInfo{})
+		if err != nil {
+			t.Fatalf("Pick returned unexpected error: %v", err)
+		}
+		counts[names[res.SubConn]]++
+	}
+
+	var totalWeight uint32
+	for _, w := range weights {
+		totalWeight += w
+	}
+	for name, weight := range weights {
+		got := float64(counts[name]) / float64(n)
+		want := float64(weight) / float64(totalWeight)
+		if math.Abs(got-want) > 0.01 {
+			t.Errorf("pick fraction for %q = %v, want ~%v", name, got, want)
+		}
+	}
+}
+
+// TestWeightedRoundRobinZeroWeight verifies that a SubConn given weight 0
+// here, which buildPicker leaves without a weight attribute, is treated as
+// weight 1. An AddrInfo carrying an explicit Weight of 0 is not exercised.
+func TestWeightedRoundRobinZeroWeight(t *testing.T) {
+	p, names := buildPicker(t, map[string]uint32{"a": 0, "b": 1})
+
+	const n = 20000
+	counts := map[string]int{}
+	for i := 0; i < n; i++ {
+		res, err := p.Pick(balancer.PickInfo{})
+		if err != nil {
+			t.Fatalf("Pick returned unexpected error: %v", err)
+		}
+		counts[names[res.SubConn]]++
+	}
+
+	got := float64(counts["a"]) / float64(n)
+	if math.Abs(got-0.5) > 0.01 {
+		t.Errorf("pick fraction for zero-weight SubConn = %v, want ~0.5 (same as weight 1)", got)
+	}
+}